@@ -0,0 +1,95 @@
+package gowsps
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"reflect"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Codec encodes and decodes the Data portion of a Packet. A PacketSystem
+// picks one Codec at construction time (Options.Codec); the packet id
+// itself always stays a VarInt ahead of the codec's bytes
+type Codec interface {
+	// Name identifies the codec in the CodecNegotiationPacketId handshake
+	Name() string
+	Encode(w io.Writer, v any) error
+	Decode(r io.Reader, v any) error
+}
+
+// BinaryCodec is the default Codec: the original custom, reflection
+// driven, wsps-tag aware varint format
+type BinaryCodec struct{}
+
+func (BinaryCodec) Name() string { return "binary" }
+
+func (BinaryCodec) Encode(w io.Writer, v any) error {
+	pb, ok := w.(*PacketBuffer)
+	if !ok {
+		pb = NewPacketBuffer()
+	}
+	if err := marshalValue(pb, reflect.ValueOf(v), fieldTag{}); err != nil {
+		return err
+	}
+	if pb != w {
+		_, err := w.Write(pb.Bytes())
+		return err
+	}
+	return nil
+}
+
+func (BinaryCodec) Decode(r io.Reader, v any) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return errors.New("wsps: Decode requires a non-nil pointer")
+	}
+	pb, ok := r.(*PacketBuffer)
+	if !ok {
+		var buf bytes.Buffer
+		if _, err := io.Copy(&buf, r); err != nil {
+			return err
+		}
+		pb = &PacketBuffer{Buffer: &buf}
+	}
+	return unmarshalValue(pb, rv.Elem(), fieldTag{})
+}
+
+// JSONCodec encodes packet payloads as JSON, mainly useful for debugging
+// a connection with tools that don't speak the binary format
+type JSONCodec struct{}
+
+func (JSONCodec) Name() string { return "json" }
+
+func (JSONCodec) Encode(w io.Writer, v any) error {
+	return json.NewEncoder(w).Encode(v)
+}
+
+func (JSONCodec) Decode(r io.Reader, v any) error {
+	return json.NewDecoder(r).Decode(v)
+}
+
+// BSONCodec encodes packet payloads as BSON documents, self-describing
+// unlike BinaryCodec since field names travel on the wire
+type BSONCodec struct{}
+
+func (BSONCodec) Name() string { return "bson" }
+
+func (BSONCodec) Encode(w io.Writer, v any) error {
+	data, err := bson.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+func (BSONCodec) Decode(r io.Reader, v any) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	return bson.Unmarshal(data, v)
+}
@@ -0,0 +1,36 @@
+package gowsps
+
+import (
+	"bytes"
+	"testing"
+)
+
+type codecTestPayload struct {
+	Name string
+	User uint8
+}
+
+// TestCodecsRoundTrip confirms every Codec implementation encodes and
+// decodes a payload back to an equal value
+func TestCodecsRoundTrip(t *testing.T) {
+	codecs := []Codec{BinaryCodec{}, JSONCodec{}, BSONCodec{}}
+
+	for _, codec := range codecs {
+		t.Run(codec.Name(), func(t *testing.T) {
+			in := codecTestPayload{Name: "Jacob", User: 2}
+
+			var buf bytes.Buffer
+			if err := codec.Encode(&buf, in); err != nil {
+				t.Fatalf("Encode: %v", err)
+			}
+
+			out := new(codecTestPayload)
+			if err := codec.Decode(&buf, out); err != nil {
+				t.Fatalf("Decode: %v", err)
+			}
+			if *out != in {
+				t.Fatalf("got %+v want %+v", *out, in)
+			}
+		})
+	}
+}
@@ -0,0 +1,97 @@
+package gowsps
+
+import (
+	"bytes"
+	"compress/zlib"
+	"io"
+	"reflect"
+)
+
+// CompressedPacketId is the packet ID reserved for the application level
+// compression fallback. When EnableCompression is set, Handlers never see
+// it: its Data is inflated and re-dispatched. With compression off it is
+// dispatched like any other packet id
+const CompressedPacketId VarInt = 0x02
+
+// DefaultCompressionThreshold is the encoded payload size, in bytes, at or
+// above which Connection.Send compresses a packet when its PacketSystem has
+// EnableCompression set
+const DefaultCompressionThreshold = 256
+
+// Options configures optional behaviour of a PacketSystem: permessage-deflate
+// style compression and which Codec encodes packet payloads
+type Options struct {
+	// EnableCompression turns on both transport level compression and the
+	// CompressedPacketId application level fallback
+	EnableCompression bool
+	// CompressionThreshold is the minimum encoded packet size, in bytes,
+	// before it is compressed. Zero uses DefaultCompressionThreshold
+	CompressionThreshold int
+	// CompressionLevel is passed to zlib/flate; zero uses
+	// zlib.DefaultCompression
+	CompressionLevel int
+	// Codec encodes and decodes packet payloads. Nil uses BinaryCodec, the
+	// original wsps wire format
+	Codec Codec
+}
+
+// withDefaults fills in zero-valued fields of o with their defaults
+func (o Options) withDefaults() Options {
+	if o.CompressionThreshold == 0 {
+		o.CompressionThreshold = DefaultCompressionThreshold
+	}
+	if o.CompressionLevel == 0 {
+		o.CompressionLevel = zlib.DefaultCompression
+	}
+	if o.Codec == nil {
+		o.Codec = BinaryCodec{}
+	}
+	return o
+}
+
+// compressedEnvelope is the structure carried as the Data of every packet
+// sent with CompressedPacketId: a zlib-compressed copy of another packet's
+// fully marshaled bytes (including that packet's own id)
+type compressedEnvelope struct {
+	Data []byte `wsps:"len=uint32"`
+}
+
+// compressFallback wraps raw (a fully marshaled packet) in a
+// CompressedPacketId envelope, returning ok=false if compressing or
+// encoding the envelope failed. The result is returned even when it is not
+// smaller than raw
+func compressFallback(raw []byte, level int) (out []byte, ok bool) {
+	var compressed bytes.Buffer
+	zw, err := zlib.NewWriterLevel(&compressed, level)
+	if err != nil {
+		return nil, false
+	}
+	if _, err = zw.Write(raw); err != nil {
+		_ = zw.Close()
+		return nil, false
+	}
+	if err = zw.Close(); err != nil {
+		return nil, false
+	}
+
+	buf := NewPacketBuffer()
+	if err = buf.WriteVarInt(CompressedPacketId); err != nil {
+		return nil, false
+	}
+	env := compressedEnvelope{Data: compressed.Bytes()}
+	if err = marshalValue(buf, reflect.ValueOf(env), fieldTag{}); err != nil {
+		return nil, false
+	}
+	return buf.Bytes(), true
+}
+
+// inflateFallback reverses compressFallback, returning the original fully
+// marshaled packet bytes
+func inflateFallback(data []byte) ([]byte, error) {
+	zr, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	return io.ReadAll(zr)
+}
@@ -0,0 +1,58 @@
+package gowsps
+
+import (
+	"compress/zlib"
+	"strings"
+	"testing"
+)
+
+// repetitiveTestPacket is a TestPacket-style payload with a long, highly
+// repetitive Name field, the kind of payload permessage-deflate compresses
+// well
+type repetitiveTestPacket struct {
+	Name string
+	User uint8
+}
+
+func newRepetitivePacket() Packet {
+	return Packet{Id: 0x02, Data: repetitiveTestPacket{
+		Name: strings.Repeat("Jacob", 500),
+		User: 2,
+	}}
+}
+
+// BenchmarkMarshalPacket_Uncompressed measures encoding the repetitive
+// payload with no compression applied
+func BenchmarkMarshalPacket_Uncompressed(b *testing.B) {
+	packet := newRepetitivePacket()
+	buf := NewPacketBuffer()
+	for i := 0; i < b.N; i++ {
+		buf.Reset()
+		if err := MarshalPacket(buf, packet); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ReportMetric(float64(buf.Len()), "bytes/op")
+}
+
+// BenchmarkMarshalPacket_Compressed measures the same payload passed
+// through the CompressedPacketId fallback, showing the size win
+// permessage-deflate style compression gets on repetitive payloads
+func BenchmarkMarshalPacket_Compressed(b *testing.B) {
+	packet := newRepetitivePacket()
+	buf := NewPacketBuffer()
+	if err := MarshalPacket(buf, packet); err != nil {
+		b.Fatal(err)
+	}
+	raw := buf.Bytes()
+
+	var size int
+	for i := 0; i < b.N; i++ {
+		compressed, ok := compressFallback(raw, zlib.DefaultCompression)
+		if !ok {
+			b.Fatal("compression was not applied")
+		}
+		size = len(compressed)
+	}
+	b.ReportMetric(float64(size), "bytes/op")
+}
@@ -0,0 +1,92 @@
+package gowsps
+
+import (
+	"context"
+	"crypto/tls"
+	"github.com/gorilla/websocket"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// Dialer is the client side counterpart to PacketSystem.UpgradeAndListen:
+// it dials a gowsps server over websockets and wires the resulting
+// *Connection to System's handler dispatch
+type Dialer struct {
+	// System receives every packet read from connections this Dialer opens
+	System *PacketSystem
+
+	// Proxy returns the proxy to use for a given request, mirroring
+	// http.Transport.Proxy and websocket.Dialer.Proxy. A nil Proxy (the
+	// default) never proxies
+	Proxy func(*http.Request) (*url.URL, error)
+	// TLSClientConfig is used for wss:// connections and for TLS to an
+	// HTTPS proxy
+	TLSClientConfig *tls.Config
+	// HandshakeTimeout bounds the websocket opening handshake; zero means
+	// no timeout, the same as websocket.Dialer.HandshakeTimeout
+	HandshakeTimeout time.Duration
+}
+
+// NewDialer creates a Dialer whose connections dispatch through s
+func NewDialer(s *PacketSystem) *Dialer {
+	return &Dialer{System: s}
+}
+
+// Dial is DialContext with context.Background()
+func (d *Dialer) Dial(urlStr string, header http.Header) (*Connection, *http.Response, error) {
+	return d.DialContext(context.Background(), urlStr, header)
+}
+
+// DialContext dials urlStr (ws:// or wss://) and starts the same read loop
+// UpgradeAndListen runs on the server. header is sent with the opening
+// handshake request
+func (d *Dialer) DialContext(ctx context.Context, urlStr string, header http.Header) (*Connection, *http.Response, error) {
+	wsd := &websocket.Dialer{
+		Proxy:             d.Proxy,
+		TLSClientConfig:   d.TLSClientConfig,
+		HandshakeTimeout:  d.HandshakeTimeout,
+		EnableCompression: d.System.Options.EnableCompression,
+	}
+
+	ws, resp, err := wsd.DialContext(ctx, urlStr, header)
+	if err != nil {
+		return nil, resp, err
+	}
+	if d.System.Options.EnableCompression {
+		_ = ws.SetCompressionLevel(d.System.Options.CompressionLevel)
+	}
+
+	conn := &Connection{
+		Open:        true,
+		Lock:        &sync.RWMutex{},
+		Conn:        ws,
+		ReadBuffer:  NewPacketBuffer(),
+		WriteBuffer: NewPacketBuffer(),
+		System:      d.System,
+	}
+
+	ws.SetCloseHandler(func(code int, text string) error {
+		conn.Open = false
+		conn.runCloseHooks()
+		return nil
+	})
+
+	installCodecNegotiation(d.System, conn)
+
+	go d.readLoop(conn)
+
+	return conn, resp, nil
+}
+
+// readLoop mirrors the loop UpgradeAndListen runs on the server
+func (d *Dialer) readLoop(conn *Connection) {
+	defer func() { _ = conn.Close() }()
+	for conn.Open {
+		err := d.System.DecodePacket(conn)
+		if err != nil && d.System.ErrorHandler != nil {
+			d.System.ErrorHandler(err)
+		}
+	}
+}
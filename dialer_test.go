@@ -0,0 +1,82 @@
+package gowsps
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestDialerRoundTrip dials a real server started with UpgradeAndListen and
+// confirms a packet sent from the server reaches a handler registered on
+// the Dialer's PacketSystem
+func TestDialerRoundTrip(t *testing.T) {
+	serverSys := NewPacketSystem()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		serverSys.UpgradeAndListen(w, r, func(conn *Connection, err error) {
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			conn.Send(Packet{Id: 0x02, Data: TestPacket{Name: "Jacob", User: 2}})
+		})
+	}))
+	t.Cleanup(ts.Close)
+
+	received := make(chan *TestPacket, 1)
+	clientSys := NewPacketSystem()
+	AddHandler(clientSys, 0x02, func(packet *TestPacket) {
+		received <- packet
+	})
+
+	wsURL := "ws" + ts.URL[len("http"):]
+	_, _, err := NewDialer(clientSys).Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+
+	select {
+	case packet := <-received:
+		if packet.Name != "Jacob" || packet.User != 2 {
+			t.Fatalf("got %+v", packet)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for the server's packet")
+	}
+}
+
+// TestDialerHeaderForwarded confirms header passed to Dial reaches the
+// server's opening handshake request, the mechanism documented for sending
+// an auth token
+func TestDialerHeaderForwarded(t *testing.T) {
+	gotHeader := make(chan string, 1)
+	serverSys := NewPacketSystem()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotHeader <- r.Header.Get("Authorization")
+		serverSys.UpgradeAndListen(w, r, func(conn *Connection, err error) {
+			if err != nil {
+				t.Error(err)
+			}
+		})
+	}))
+	t.Cleanup(ts.Close)
+
+	clientSys := NewPacketSystem()
+	wsURL := "ws" + ts.URL[len("http"):]
+	header := http.Header{"Authorization": {"Bearer test-token"}}
+	_, _, err := NewDialer(clientSys).Dial(wsURL, header)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+
+	select {
+	case got := <-gotHeader:
+		if got != "Bearer test-token" {
+			t.Fatalf("got Authorization %q", got)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for the server's request")
+	}
+}
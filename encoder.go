@@ -41,20 +41,62 @@ func (p *PacketBuffer) ReadByteArray(length VarInt) ([]byte, error) {
 	return buff, nil
 }
 
+// writeLength writes n using the length prefix width requested by a `len`
+// struct tag, defaulting to a plain VarInt
+func (p *PacketBuffer) writeLength(n int, width lenWidth) error {
+	switch width {
+	case lenWidthUint16:
+		return binary.Write(p, binary.BigEndian, uint16(n))
+	case lenWidthUint32:
+		return binary.Write(p, binary.BigEndian, uint32(n))
+	default:
+		return p.WriteVarInt(VarInt(n))
+	}
+}
+
+// readLength reads a length prefix written by writeLength
+func (p *PacketBuffer) readLength(width lenWidth) (int, error) {
+	switch width {
+	case lenWidthUint16:
+		var v uint16
+		if err := binary.Read(p, binary.BigEndian, &v); err != nil {
+			return 0, err
+		}
+		return int(v), nil
+	case lenWidthUint32:
+		var v uint32
+		if err := binary.Read(p, binary.BigEndian, &v); err != nil {
+			return 0, err
+		}
+		return int(v), nil
+	default:
+		v, err := binary.ReadUvarint(p)
+		return int(v), err
+	}
+}
+
 func (p *PacketBuffer) WriteString(value string) error {
+	return p.writeTaggedString(value, lenWidthVarint)
+}
+
+// writeTaggedString writes value with a length prefix of the given width,
+// backing the `len` struct tag on string fields
+func (p *PacketBuffer) writeTaggedString(value string, width lenWidth) error {
 	v := []byte(value)
-	err := p.WriteVarInt(VarInt(len(v)))
-	if err != nil {
-		return err
-	}
-	if err = binary.Write(p, binary.BigEndian, v); err != nil {
+	if err := p.writeLength(len(v), width); err != nil {
 		return err
 	}
-	return nil
+	_, err := p.Write(v)
+	return err
 }
 
 func (p *PacketBuffer) ReadString() (string, error) {
-	l, err := binary.ReadUvarint(p)
+	return p.readTaggedString(lenWidthVarint)
+}
+
+// readTaggedString reads a string written by writeTaggedString
+func (p *PacketBuffer) readTaggedString(width lenWidth) (string, error) {
+	l, err := p.readLength(width)
 	if err != nil {
 		return "", err
 	}
@@ -65,89 +107,88 @@ func (p *PacketBuffer) ReadString() (string, error) {
 	return string(buff), nil
 }
 
+// MarshalPacket writes packet.Id followed by packet.Data encoded with
+// BinaryCodec. It is used directly by the codec negotiation handshake, which
+// is always binary regardless of a PacketSystem's chosen Codec (the
+// compression envelope and the payloads carried inside a request envelope
+// are likewise always BinaryCodec); Connection.Send uses
+// conn.System.Options.Codec instead so user packets, mux frames and request
+// envelopes follow the codec a PacketSystem was constructed with
 func MarshalPacket(p *PacketBuffer, packet Packet) error {
-	err := p.WriteVarInt(packet.Id)
-	if err != nil {
+	if err := p.WriteVarInt(packet.Id); err != nil {
 		return err
 	}
-	err = marshalPacketData(p, packet.Data)
-	if err != nil {
-		return err
-	}
-	return nil
+	return BinaryCodec{}.Encode(p, packet.Data)
 }
 
-func marshalPacketData(p *PacketBuffer, data any) error {
-	err := marshalValue(p, data)
-	if err != nil {
-		return err
+// marshalValue encodes v, consulting tag for options that apply at this
+// position (len width, varint/fixed, enum). Struct fields get their own
+// tag from the cached typeInfo of their struct type, so tag only ever
+// flows one level deep from whoever called marshalValue
+func marshalValue(p *PacketBuffer, v reflect.Value, tag fieldTag) error {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return nil
+		}
+		return marshalValue(p, v.Elem(), tag)
+	case reflect.Struct:
+		return marshalStruct(p, v)
+	case reflect.Slice:
+		return marshalSlice(p, v, tag)
+	case reflect.Map:
+		return marshalMap(p, v, tag)
+	default:
+		return marshalPrimitive(p, v, tag)
 	}
-	return nil
 }
 
-func marshalValue(p *PacketBuffer, b any) error {
-	x := reflect.ValueOf(b)
-	rk := x.Kind()
-	var err error
-	switch rk {
-	case reflect.Struct:
-		fc := x.NumField()
-		for i := 0; i < fc; i++ {
-			fb := x.Field(i)
-			v := fb.Interface()
-			err = marshalValue(p, v)
-		}
-	case reflect.Slice:
-		err := marshalSlice(p, b)
-		if err != nil {
+// marshalStruct writes every non-skip field of v in declaration order,
+// applying each field's own `wsps` tag
+func marshalStruct(p *PacketBuffer, v reflect.Value) error {
+	info := getTypeInfo(v.Type())
+	for _, f := range info.Fields {
+		if err := marshalField(p, v.Field(f.Index), f.Tag); err != nil {
 			return err
 		}
-	case reflect.Map:
-		err := marshalMap(p, b)
-		if err != nil {
+	}
+	return nil
+}
+
+// marshalField applies the `optional` presence flag (if tagged) before
+// deferring to marshalValue for the field's actual value
+func marshalField(p *PacketBuffer, fv reflect.Value, tag fieldTag) error {
+	if tag.Optional {
+		present := !fv.IsZero()
+		if err := p.WriteByte(boolToByte(present)); err != nil {
 			return err
 		}
-	default:
-		err = marshalPrimitive(p, reflect.ValueOf(b))
-		if err != nil {
-			return err
+		if !present {
+			return nil
 		}
 	}
-	return err
+	return marshalValue(p, fv, tag)
 }
 
-func marshalSlice(p *PacketBuffer, v any) error {
-	t := reflect.TypeOf(v)
-	vl := reflect.ValueOf(v)
-	l := vl.Len()
-	err := p.WriteVarInt(VarInt(l))
-	if err != nil {
+func marshalSlice(p *PacketBuffer, v reflect.Value, tag fieldTag) error {
+	l := v.Len()
+	if err := p.writeLength(l, tag.LenWidth); err != nil {
 		return err
 	}
-	tk := t.Elem().Kind()
-	fmt.Println(tk, l)
-	switch tk {
-	case reflect.Struct:
-		for i := 0; i < l; i++ {
-			vi := vl.Index(i).Interface()
-			err := marshalValue(p, vi)
-			if err != nil {
-				return err
-			}
-		}
-	case reflect.Slice:
+	elemTag := elementTag(tag)
+	switch v.Type().Elem().Kind() {
+	case reflect.Uint8:
+		_, err := p.Write(v.Bytes())
+		return err
+	case reflect.Struct, reflect.Slice:
 		for i := 0; i < l; i++ {
-			vi := vl.Index(i).Interface()
-			err := marshalSlice(p, vi)
-			if err != nil {
+			if err := marshalValue(p, v.Index(i), elemTag); err != nil {
 				return err
 			}
 		}
 	default:
 		for i := 0; i < l; i++ {
-			vi := vl.Index(i)
-			err := marshalPrimitive(p, vi)
-			if err != nil {
+			if err := marshalPrimitive(p, v.Index(i), elemTag); err != nil {
 				return err
 			}
 		}
@@ -155,182 +196,273 @@ func marshalSlice(p *PacketBuffer, v any) error {
 	return nil
 }
 
-func marshalMap(p *PacketBuffer, v any) error {
-	vl := reflect.ValueOf(v)
-	count := vl.Len()
-	err := p.WriteVarInt(VarInt(count))
-	if err != nil {
+func marshalMap(p *PacketBuffer, v reflect.Value, tag fieldTag) error {
+	if err := p.WriteVarInt(VarInt(v.Len())); err != nil {
 		return err
 	}
-	keys := vl.MapKeys()
-	for _, key := range keys {
-		f := vl.MapIndex(key)
-		ki := key.Interface()
-		vi := f.Interface()
-		err = marshalPrimitive(p, reflect.ValueOf(ki))
-		if err != nil {
+	elemTag := elementTag(tag)
+	iter := v.MapRange()
+	for iter.Next() {
+		if err := marshalPrimitive(p, iter.Key(), elemTag); err != nil {
 			return err
 		}
-		err = marshalValue(p, vi)
-		if err != nil {
+		if err := marshalValue(p, iter.Value(), elemTag); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-func marshalPrimitive(p *PacketBuffer, r reflect.Value) error {
-	v := r.Interface()
-	switch v.(type) {
+// elementTag strips the options that only make sense on the field itself
+// (the length prefix width, the optional presence flag) from tag, keeping
+// the ones that apply per-element (varint, fixed, enum) for marshalSlice
+// and marshalMap to pass down to their elements
+func elementTag(tag fieldTag) fieldTag {
+	return fieldTag{Varint: tag.Varint, Fixed: tag.Fixed, Enum: tag.Enum}
+}
+
+func marshalPrimitive(p *PacketBuffer, v reflect.Value, tag fieldTag) error {
+	switch val := v.Interface().(type) {
 	case VarInt:
-		err := p.WriteVarInt(v.(VarInt))
-		if err != nil {
-			return err
+		if tag.Enum != "" {
+			if err := validateEnum(tag.Enum, uint64(val)); err != nil {
+				return err
+			}
 		}
-	case bool, uint8, uint16, uint32, int8,
-		int16, int32, float32, float64:
-		if err := binary.Write(p, binary.BigEndian, v); err != nil {
-			return err
+		if tag.Fixed {
+			return binary.Write(p, binary.BigEndian, uint64(val))
 		}
-	case string:
-		if err := p.WriteString(v.(string)); err != nil {
-			return err
+		return p.WriteVarInt(val)
+	case bool:
+		return binary.Write(p, binary.BigEndian, val)
+	case uint8, uint16, uint32, int8, int16, int32, float32, float64:
+		if u, ok := toUint64(val); ok {
+			if tag.Enum != "" {
+				if err := validateEnum(tag.Enum, u); err != nil {
+					return err
+				}
+			}
+			if tag.Varint {
+				return p.WriteVarInt(VarInt(u))
+			}
 		}
+		return binary.Write(p, binary.BigEndian, val)
+	case string:
+		return p.writeTaggedString(val, tag.LenWidth)
 	}
 	return nil
 }
 
+// UnMarshalPacket decodes a packet's Data out of p using BinaryCodec. Like
+// MarshalPacket it backs the parts of the wire protocol that stay binary
+// regardless of a PacketSystem's chosen Codec; AddHandler decodes user
+// packets through s.Options.Codec instead
 func UnMarshalPacket(p *PacketBuffer, out any) error {
-	err := unmarshalValue(p, out)
-	return err
+	return BinaryCodec{}.Decode(p, out)
 }
 
-func unmarshalValue(p *PacketBuffer, b any) error {
-	x := reflect.ValueOf(b)
-	rk := x.Kind()
-	var err error
-	switch rk {
-	case reflect.Struct:
-		fc := x.NumField()
-		for i := 0; i < fc; i++ {
-			fb := x.Field(i)
-			v := fb.Interface()
-			err = unmarshalValue(p, v)
+// unmarshalValue decodes into the addressable value v, the counterpart of
+// marshalValue. v must be settable (obtained through a pointer's Elem(),
+// or a struct/slice/map element reached from one) or Set calls below panic
+func unmarshalValue(p *PacketBuffer, v reflect.Value, tag fieldTag) error {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
 		}
+		return unmarshalValue(p, v.Elem(), tag)
+	case reflect.Struct:
+		return unmarshalStruct(p, v)
 	case reflect.Slice:
-		err = unmarshalSlice(p, b)
-		if err != nil {
-			return err
-		}
+		return unmarshalSlice(p, v, tag)
 	case reflect.Map:
-		err = unmarshalMap(p, b)
-		if err != nil {
+		return unmarshalMap(p, v, tag)
+	default:
+		return unmarshalPrimitive(p, v, tag)
+	}
+}
+
+func unmarshalStruct(p *PacketBuffer, v reflect.Value) error {
+	info := getTypeInfo(v.Type())
+	for _, f := range info.Fields {
+		if err := unmarshalField(p, v.Field(f.Index), f.Tag); err != nil {
 			return err
 		}
-	default:
-		err = unmarshalPrimitive(p, reflect.ValueOf(b))
+	}
+	return nil
+}
+
+// unmarshalField mirrors marshalField: it consumes the `optional` presence
+// flag (if tagged) before deferring to unmarshalValue
+func unmarshalField(p *PacketBuffer, fv reflect.Value, tag fieldTag) error {
+	if tag.Optional {
+		b, err := p.ReadByte()
 		if err != nil {
 			return err
 		}
+		if b == 0 {
+			fv.Set(reflect.Zero(fv.Type()))
+			return nil
+		}
 	}
-	return err
+	return unmarshalValue(p, fv, tag)
 }
 
-func unmarshalSlice(p *PacketBuffer, v any) error {
-	t := reflect.TypeOf(v)
-	vl := reflect.ValueOf(v)
-	le, err := binary.ReadUvarint(p)
+func unmarshalSlice(p *PacketBuffer, v reflect.Value, tag fieldTag) error {
+	l, err := p.readLength(tag.LenWidth)
 	if err != nil {
 		return err
 	}
-	l := int(le)
-	te := t.Elem()
-	tk := te.Kind()
-	vl.SetLen(l)
-	switch tk {
-	case reflect.Struct:
-		for i := 0; i < l; i++ {
-			vi := vl.Index(i)
-			err = unmarshalValue(p, vi)
-			if err != nil {
-				return err
-			}
+	t := v.Type()
+	if t.Elem().Kind() == reflect.Uint8 {
+		buff, err := p.ReadByteArray(VarInt(l))
+		if err != nil {
+			return err
 		}
-	case reflect.Slice:
+		v.SetBytes(buff)
+		return nil
+	}
+
+	ns := reflect.MakeSlice(t, l, l)
+	elemTag := elementTag(tag)
+	switch t.Elem().Kind() {
+	case reflect.Struct, reflect.Slice:
 		for i := 0; i < l; i++ {
-			vi := vl.Index(i)
-			err = unmarshalSlice(p, vi)
-			if err != nil {
+			if err := unmarshalValue(p, ns.Index(i), elemTag); err != nil {
 				return err
 			}
 		}
-	case reflect.Uint8:
-		buff := make([]byte, l)
-		count, err := io.ReadFull(p, buff)
-		if err != nil {
-			return err
-		}
-		if count != int(l) {
-			return errors.New("incorrect length")
-		}
-		vl.SetBytes(buff)
 	default:
 		for i := 0; i < l; i++ {
-			vi := vl.Index(i)
-			err = unmarshalPrimitive(p, vi)
-			if err != nil {
+			if err := unmarshalPrimitive(p, ns.Index(i), elemTag); err != nil {
 				return err
 			}
 		}
 	}
+	v.Set(ns)
 	return nil
 }
 
-func unmarshalMap(p *PacketBuffer, v any) error {
+func unmarshalMap(p *PacketBuffer, v reflect.Value, tag fieldTag) error {
 	count, err := binary.ReadUvarint(p)
 	if err != nil {
 		return err
 	}
-	t := reflect.TypeOf(v)
+	t := v.Type()
+	m := reflect.MakeMapWithSize(t, int(count))
 	kt := t.Key()
 	vt := t.Elem()
+	elemTag := elementTag(tag)
 	for i := uint64(0); i < count; i++ {
-		key := reflect.New(kt)
-		err = unmarshalPrimitive(p, key)
-		if err != nil {
+		key := reflect.New(kt).Elem()
+		if err := unmarshalPrimitive(p, key, elemTag); err != nil {
 			return err
 		}
-		value := reflect.New(vt)
-		err = unmarshalValue(p, value.Interface())
-		if err != nil {
+		value := reflect.New(vt).Elem()
+		if err := unmarshalValue(p, value, elemTag); err != nil {
 			return err
 		}
+		m.SetMapIndex(key, value)
 	}
+	v.Set(m)
 	return nil
 }
 
-func unmarshalPrimitive(p *PacketBuffer, r reflect.Value) error {
-	v := r.Interface()
-	switch v.(type) {
+func unmarshalPrimitive(p *PacketBuffer, v reflect.Value, tag fieldTag) error {
+	switch v.Interface().(type) {
 	case VarInt:
-		val, err := binary.ReadUvarint(p)
+		if tag.Fixed {
+			var u uint64
+			if err := binary.Read(p, binary.BigEndian, &u); err != nil {
+				return err
+			}
+			if tag.Enum != "" {
+				if err := validateEnum(tag.Enum, u); err != nil {
+					return err
+				}
+			}
+			v.SetUint(u)
+			return nil
+		}
+		u, err := binary.ReadUvarint(p)
 		if err != nil {
 			return err
 		}
-		r.Set(reflect.ValueOf(VarInt(val)))
-	case uint8, uint16, uint32,
-		int8, int16, int32,
-		float32, float64, bool:
-		if err := binary.Read(p, binary.BigEndian, &v); err != nil {
+		if tag.Enum != "" {
+			if err := validateEnum(tag.Enum, u); err != nil {
+				return err
+			}
+		}
+		v.SetUint(u)
+		return nil
+	case bool:
+		var b bool
+		if err := binary.Read(p, binary.BigEndian, &b); err != nil {
 			return err
 		}
-		r.Set(reflect.ValueOf(v))
+		v.SetBool(b)
+		return nil
+	case uint8, uint16, uint32, int8, int16, int32, float32, float64:
+		if tag.Varint {
+			u, err := binary.ReadUvarint(p)
+			if err != nil {
+				return err
+			}
+			if tag.Enum != "" {
+				if err := validateEnum(tag.Enum, u); err != nil {
+					return err
+				}
+			}
+			return setUintOrInt(v, u)
+		}
+		return readFixedPrimitive(p, v, tag)
 	case string:
-		val, err := p.ReadString()
+		s, err := p.readTaggedString(tag.LenWidth)
 		if err != nil {
 			return err
 		}
-		r.SetString(val)
+		v.SetString(s)
+		return nil
 	}
 	return nil
 }
+
+// readFixedPrimitive reads a big-endian fixed-width value into a freshly
+// allocated value of v's concrete type, validates it against tag.Enum if
+// set, and stores it into v
+func readFixedPrimitive(p *PacketBuffer, v reflect.Value, tag fieldTag) error {
+	ptr := reflect.New(v.Type())
+	if err := binary.Read(p, binary.BigEndian, ptr.Interface()); err != nil {
+		return err
+	}
+	if tag.Enum != "" {
+		if u, ok := toUint64(ptr.Elem().Interface()); ok {
+			if err := validateEnum(tag.Enum, u); err != nil {
+				return err
+			}
+		}
+	}
+	v.Set(ptr.Elem())
+	return nil
+}
+
+// setUintOrInt stores u into v, which must be one of the unsigned or
+// signed integer kinds marshalPrimitive accepts a `varint` tag for
+func setUintOrInt(v reflect.Value, u uint64) error {
+	switch v.Kind() {
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		v.SetUint(u)
+	case reflect.Int8, reflect.Int16, reflect.Int32:
+		v.SetInt(int64(u))
+	default:
+		return fmt.Errorf("wsps: varint tag not supported for %s", v.Kind())
+	}
+	return nil
+}
+
+func boolToByte(b bool) byte {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,201 @@
+package gowsps
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+)
+
+// MuxPacketId is the packet ID reserved for multiplexed stream frames.
+// Applications using Mux should not register their own handler for it
+const MuxPacketId VarInt = 0x00
+
+// Mux opcodes identify what a MuxFrame represents on the wire
+const (
+	muxOpOpen         VarInt = iota // opens a new logical stream
+	muxOpData                       // carries a chunk of stream payload
+	muxOpClose                      // half-closes (or fully closes) a stream
+	muxOpErr                        // reports a stream level error
+	muxOpWindowUpdate               // grants additional write credit
+)
+
+// initialStreamWindow is the number of bytes of write credit a Stream
+// starts with and is replenished to as the peer reads data
+const initialStreamWindow = 64 * 1024
+
+// MuxFrame is carried as the Data of every packet sent with MuxPacketId.
+// Payload holds the stream payload for muxOpData, an error message for
+// muxOpErr, or a varint credit count for muxOpWindowUpdate
+type MuxFrame struct {
+	StreamId VarInt
+	Op       VarInt
+	Payload  []byte
+}
+
+// Router looks up the backend connection a newly opened Stream should be
+// proxied to, supplied by the server application
+type Router interface {
+	// LookupTarget resolves addr to a backend connection for the Stream
+	LookupTarget(addr string) (net.Conn, error)
+}
+
+// Mux multiplexes many logical Stream values over a single *Connection by
+// reserving MuxPacketId on its PacketSystem
+type Mux struct {
+	conn   *Connection
+	router Router
+
+	lock        sync.Mutex
+	streams     map[VarInt]*Stream
+	nextStream  VarInt
+	acceptQueue chan *Stream
+	closed      bool
+}
+
+// NewMux creates a Mux bound to conn and registers the mux frame handler
+// on s. router may be nil on a client that never calls Mux.Accept
+func NewMux(s *PacketSystem, conn *Connection, router Router) *Mux {
+	m := &Mux{
+		conn:        conn,
+		router:      router,
+		streams:     map[VarInt]*Stream{},
+		acceptQueue: make(chan *Stream, 16),
+	}
+	AddHandler(s, MuxPacketId, m.onFrame)
+	conn.OnClose(func() { _ = m.Close() })
+	return m
+}
+
+// Open starts a new logical Stream to the peer, asking it to proxy the
+// stream to remoteAddr via its Router
+func (m *Mux) Open(remoteAddr string) (*Stream, error) {
+	m.lock.Lock()
+	if m.closed {
+		m.lock.Unlock()
+		return nil, errors.New("mux is closed")
+	}
+	id := m.nextStream
+	m.nextStream++
+	st := newStream(m, id)
+	m.streams[id] = st
+	m.lock.Unlock()
+
+	m.send(id, muxOpOpen, []byte(remoteAddr))
+	return st, nil
+}
+
+// Accept blocks until the peer opens a new Stream
+func (m *Mux) Accept() (*Stream, error) {
+	st, ok := <-m.acceptQueue
+	if !ok {
+		return nil, errors.New("mux is closed")
+	}
+	return st, nil
+}
+
+// Close tears down every open Stream and stops accepting new ones
+func (m *Mux) Close() error {
+	m.lock.Lock()
+	if m.closed {
+		m.lock.Unlock()
+		return nil
+	}
+	m.closed = true
+	streams := make([]*Stream, 0, len(m.streams))
+	for _, st := range m.streams {
+		streams = append(streams, st)
+	}
+	close(m.acceptQueue)
+	m.lock.Unlock()
+
+	for _, st := range streams {
+		st.closeLocal(nil)
+	}
+	return nil
+}
+
+// send writes a MuxFrame for the given stream over the mux's Connection
+func (m *Mux) send(id VarInt, op VarInt, payload []byte) {
+	m.conn.Send(Packet{Id: MuxPacketId, Data: MuxFrame{StreamId: id, Op: op, Payload: payload}})
+}
+
+// onFrame dispatches an incoming MuxFrame to its Stream, creating one on
+// muxOpOpen
+func (m *Mux) onFrame(frame *MuxFrame) {
+	switch frame.Op {
+	case muxOpOpen:
+		m.handleOpen(frame)
+		return
+	}
+
+	m.lock.Lock()
+	st, ok := m.streams[frame.StreamId]
+	m.lock.Unlock()
+	if !ok {
+		return
+	}
+
+	switch frame.Op {
+	case muxOpData:
+		st.pushData(frame.Payload)
+	case muxOpClose:
+		st.closeRemote()
+	case muxOpErr:
+		st.closeLocal(errors.New(string(frame.Payload)))
+	case muxOpWindowUpdate:
+		n, _ := binary.Uvarint(frame.Payload)
+		st.addCredit(VarInt(n))
+	}
+}
+
+// handleOpen creates the Stream for a muxOpOpen frame, wires it to the
+// Router's backend target, and hands it to Accept
+func (m *Mux) handleOpen(frame *MuxFrame) {
+	st := newStream(m, frame.StreamId)
+
+	m.lock.Lock()
+	if m.closed {
+		m.lock.Unlock()
+		return
+	}
+	m.streams[frame.StreamId] = st
+	m.lock.Unlock()
+
+	if m.router != nil {
+		target, err := m.router.LookupTarget(string(frame.Payload))
+		if err != nil {
+			m.send(frame.StreamId, muxOpErr, []byte(err.Error()))
+			st.closeLocal(err)
+			return
+		}
+		go proxyStream(st, target)
+	}
+
+	m.lock.Lock()
+	if m.closed {
+		m.lock.Unlock()
+		return
+	}
+	m.acceptQueue <- st
+	m.lock.Unlock()
+}
+
+// proxyStream copies data between a locally accepted Stream and the
+// backend net.Conn a Router resolved it to, closing both sides together
+func proxyStream(st *Stream, target io.ReadWriteCloser) {
+	defer target.Close()
+	defer st.Close()
+
+	done := make(chan struct{}, 2)
+	go func() {
+		_, _ = io.Copy(target, st)
+		done <- struct{}{}
+	}()
+	go func() {
+		_, _ = io.Copy(st, target)
+		done <- struct{}{}
+	}()
+	<-done
+}
@@ -0,0 +1,234 @@
+package gowsps
+
+import (
+	"bytes"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// echoRouter proxies every opened stream to a net.Pipe whose far end echoes
+// back whatever it reads, so a client Write round-trips to a client Read
+type echoRouter struct{}
+
+func (echoRouter) LookupTarget(addr string) (net.Conn, error) {
+	target, echo := net.Pipe()
+	go func() {
+		buf := make([]byte, 4096)
+		for {
+			n, err := echo.Read(buf)
+			if n > 0 {
+				if _, werr := echo.Write(buf[:n]); werr != nil {
+					return
+				}
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	return target, nil
+}
+
+// newMuxPair starts an httptest server running a Mux bound to router and
+// dials it with a client Mux, returning both once the connection is up. A
+// nil router leaves every opened stream for a manual Accept instead of
+// auto-proxying it, so tests that Accept and drive a Stream directly don't
+// race a background proxyStream goroutine for the same bytes
+func newMuxPair(t *testing.T, router Router) (client *Mux, server *Mux) {
+	t.Helper()
+	serverSys := NewPacketSystem()
+	serverReady := make(chan *Mux, 1)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		serverSys.UpgradeAndListen(w, r, func(conn *Connection, err error) {
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			serverReady <- NewMux(serverSys, conn, router)
+		})
+	}))
+	t.Cleanup(ts.Close)
+
+	clientSys := NewPacketSystem()
+	wsURL := "ws" + ts.URL[len("http"):]
+	conn, _, err := NewDialer(clientSys).Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+
+	client = NewMux(clientSys, conn, nil)
+	select {
+	case server = <-serverReady:
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for server Mux")
+	}
+	return client, server
+}
+
+// TestMuxOpenAcceptDataClose exercises the lifecycle of a Stream from the
+// client side: the client opens one, the server side proxies it to
+// echoRouter's backend, data the client writes is echoed back and read on
+// the client Stream, and closing the client Stream succeeds
+func TestMuxOpenAcceptDataClose(t *testing.T) {
+	client, _ := newMuxPair(t, echoRouter{})
+
+	st, err := client.Open("backend")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+
+	payload := []byte("hello from the mux")
+	if _, err := st.Write(payload); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	got := make([]byte, len(payload))
+	if err := readFull(t, st, got); err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	if !bytes.Equal(got, payload) {
+		t.Fatalf("got %q want %q", got, payload)
+	}
+
+	if err := st.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+}
+
+// TestStreamBackpressure confirms a Stream's write credit is only granted
+// back as the peer drains its read buffer: a Write far larger than
+// initialStreamWindow must block while the peer never calls Read, then
+// complete once the peer starts reading, with every byte delivered intact
+func TestStreamBackpressure(t *testing.T) {
+	client, server := newMuxPair(t, nil)
+
+	st, err := client.Open("backend")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+
+	peer, err := server.Accept()
+	if err != nil {
+		t.Fatalf("Accept: %v", err)
+	}
+
+	payload := bytes.Repeat([]byte("x"), initialStreamWindow*3)
+	writeDone := make(chan error, 1)
+	go func() {
+		_, werr := st.Write(payload)
+		writeDone <- werr
+	}()
+
+	select {
+	case <-writeDone:
+		t.Fatal("Write completed without the peer ever reading; back-pressure is not working")
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	got := make([]byte, len(payload))
+	if err := readFull(t, peer, got); err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+
+	select {
+	case err := <-writeDone:
+		if err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Write never completed after the peer drained the buffer")
+	}
+
+	if !bytes.Equal(got, payload) {
+		t.Fatal("bytes corrupted across the backpressure window")
+	}
+}
+
+// slowRouter blocks in LookupTarget until released, modelling a backend
+// lookup that's still in flight when the Mux is closed
+type slowRouter struct {
+	release chan struct{}
+}
+
+func (r slowRouter) LookupTarget(addr string) (net.Conn, error) {
+	<-r.release
+	target, _ := net.Pipe()
+	return target, nil
+}
+
+// TestMuxCloseDuringHandleOpen confirms Close can run concurrently with a
+// handleOpen still blocked in Router.LookupTarget without panicking on a
+// send to the closed acceptQueue
+func TestMuxCloseDuringHandleOpen(t *testing.T) {
+	router := slowRouter{release: make(chan struct{})}
+	client, server := newMuxPair(t, router)
+
+	if _, err := client.Open("backend"); err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+
+	// Give handleOpen a moment to reach LookupTarget before closing
+	time.Sleep(50 * time.Millisecond)
+	if err := server.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	close(router.release)
+
+	// Let the now-unblocked handleOpen run; it must not panic
+	time.Sleep(50 * time.Millisecond)
+}
+
+// TestStreamCloseWritePreservesRead confirms CloseWrite only half-closes
+// the write side: data the peer already sent (or sends after observing the
+// half-close) is still delivered to Read, unlike Close which tears the
+// stream down for both directions
+func TestStreamCloseWritePreservesRead(t *testing.T) {
+	client, server := newMuxPair(t, nil)
+
+	st, err := client.Open("backend")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	peer, err := server.Accept()
+	if err != nil {
+		t.Fatalf("Accept: %v", err)
+	}
+
+	if err := st.CloseWrite(); err != nil {
+		t.Fatalf("CloseWrite: %v", err)
+	}
+
+	payload := []byte("still readable after CloseWrite")
+	if _, err := peer.Write(payload); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	got := make([]byte, len(payload))
+	if err := readFull(t, st, got); err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	if !bytes.Equal(got, payload) {
+		t.Fatalf("got %q want %q", got, payload)
+	}
+
+	if _, err := st.Write([]byte("x")); err == nil {
+		t.Fatal("expected Write to fail after CloseWrite")
+	}
+}
+
+func readFull(t *testing.T, st *Stream, out []byte) error {
+	t.Helper()
+	read := 0
+	for read < len(out) {
+		n, err := st.Read(out[read:])
+		read += n
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package gowsps
+
+import (
+	"fmt"
+	"github.com/gorilla/websocket"
+)
+
+// CodecNegotiationPacketId is reserved for the codec negotiation handshake
+// that UpgradeAndListen and Dialer.DialContext send as soon as a
+// Connection is established, advertising the sending PacketSystem's Codec.
+// It is always framed with BinaryCodec so a mismatched pair can still read
+// the handshake and fail fast with a clear error
+const CodecNegotiationPacketId VarInt = 0x03
+
+// codecNegotiation is the payload of the handshake: the name of the
+// sender's Codec
+type codecNegotiation struct {
+	Codec string
+}
+
+// installCodecNegotiation registers the CodecNegotiationPacketId handler
+// on s (once per PacketSystem) and sends conn's advertisement to the peer
+func installCodecNegotiation(s *PacketSystem, conn *Connection) {
+	s.handlersLock.Lock()
+	if _, exists := s.Handlers[CodecNegotiationPacketId]; !exists {
+		s.Handlers[CodecNegotiationPacketId] = func(c *Connection) {
+			env := new(codecNegotiation)
+			if err := UnMarshalPacket(c.ReadBuffer, env); err != nil {
+				return
+			}
+			if env.Codec != s.Options.Codec.Name() {
+				err := fmt.Errorf("wsps: codec mismatch: peer advertised %q, this PacketSystem uses %q", env.Codec, s.Options.Codec.Name())
+				if s.ErrorHandler != nil {
+					s.ErrorHandler(err)
+				}
+				c.Open = false
+				_ = c.Close()
+			}
+		}
+	}
+	s.handlersLock.Unlock()
+
+	buf := NewPacketBuffer()
+	_ = MarshalPacket(buf, Packet{Id: CodecNegotiationPacketId, Data: codecNegotiation{Codec: s.Options.Codec.Name()}})
+	conn.Lock.Lock()
+	_ = conn.WriteMessage(websocket.BinaryMessage, buf.Bytes())
+	conn.Lock.Unlock()
+}
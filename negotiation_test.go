@@ -0,0 +1,68 @@
+package gowsps
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// dialWithCodec starts an UpgradeAndListen server using serverCodec and
+// dials it with a client PacketSystem using clientCodec, returning the
+// client's error handler errors channel so a test can watch for a codec
+// mismatch (the client is the side configured with SetErrorHandler here
+// since ws.SetCloseHandler only fires on a completed close handshake, not
+// on the local, unilateral c.Close() the mismatch handler calls)
+func dialWithCodec(t *testing.T, serverCodec, clientCodec Codec) (errs chan error) {
+	t.Helper()
+	serverSys := NewPacketSystem(Options{Codec: serverCodec})
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		serverSys.UpgradeAndListen(w, r, func(conn *Connection, err error) {
+			if err != nil {
+				t.Error(err)
+			}
+		})
+	}))
+	t.Cleanup(ts.Close)
+
+	errs = make(chan error, 1)
+	clientSys := NewPacketSystem(Options{Codec: clientCodec})
+	clientSys.SetErrorHandler(func(err error) { errs <- err })
+
+	wsURL := "ws" + ts.URL[len("http"):]
+	_, _, err := NewDialer(clientSys).Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	return errs
+}
+
+// TestCodecNegotiationMismatchReported confirms a PacketSystem dialing a
+// peer advertising a different Codec gets a clear mismatch error instead of
+// silently failing to decode the peer's packets
+func TestCodecNegotiationMismatchReported(t *testing.T) {
+	errs := dialWithCodec(t, JSONCodec{}, BSONCodec{})
+
+	select {
+	case err := <-errs:
+		if err == nil {
+			t.Fatal("expected a non-nil mismatch error")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for the codec mismatch error")
+	}
+}
+
+// TestCodecNegotiationMatchReportsNoError confirms two PacketSystems
+// configured with the same Codec complete the handshake without the
+// mismatch error ever firing
+func TestCodecNegotiationMatchReportsNoError(t *testing.T) {
+	errs := dialWithCodec(t, JSONCodec{}, JSONCodec{})
+
+	select {
+	case err := <-errs:
+		t.Fatalf("unexpected error handler call: %v", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
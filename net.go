@@ -21,6 +21,23 @@ type ErrorHandler func(err error)
 type PacketSystem struct {
 	Handlers     map[VarInt]PacketDecoder
 	ErrorHandler ErrorHandler
+	Options      Options
+
+	// handlersLock guards Handlers, requestHandlers and requests. A
+	// Connection's read loop starts dispatching packets through Handlers as
+	// soon as it's created (immediately for a Dialer, after the
+	// UpgradeAndListen callback for a server), so anything that registers a
+	// handler later - AddHandler, AddRequestHandler, installRequestState,
+	// installCodecNegotiation - can race with decodeFrame's lookup on a
+	// live connection unless both go through this lock
+	handlersLock sync.RWMutex
+
+	// requests holds the request/response bookkeeping lazily installed by
+	// Connection.Request or AddRequestHandler; nil until first used
+	requests *requestState
+	// requestHandlers maps a packet id to the request handler registered
+	// for it with AddRequestHandler
+	requestHandlers map[VarInt]func(c *Connection, env *requestEnvelope)
 }
 
 type Packet struct {
@@ -33,10 +50,17 @@ func (s *PacketSystem) SetErrorHandler(handler ErrorHandler) {
 }
 
 // NewPacketSystem creates a new packet system and returns a handle to the
-// newly created packet system
-func NewPacketSystem() *PacketSystem {
+// newly created packet system. An optional Options may be passed to
+// configure it, for example to turn on compression; the zero value
+// (NewPacketSystem() with no arguments) keeps the previous defaults
+func NewPacketSystem(opts ...Options) *PacketSystem {
+	o := Options{}
+	if len(opts) > 0 {
+		o = opts[0]
+	}
 	s := PacketSystem{
 		Handlers: map[VarInt]PacketDecoder{},
+		Options:  o.withDefaults(),
 	}
 	return &s
 }
@@ -54,17 +78,63 @@ type Connection struct {
 	ReadBuffer  *PacketBuffer
 	WriteBuffer *PacketBuffer
 
+	// System is the PacketSystem dispatching packets for this Connection.
+	// It is set by UpgradeAndListen (and the Dialer) and lets Connection
+	// methods such as Request reach the handler/request bookkeeping
+	// without the caller threading the PacketSystem through every call
+	System *PacketSystem
+
+	closeHooksLock sync.Mutex
+	closeHooks     []func()
+
 	*websocket.Conn
 }
 
+// OnClose registers fn to run when the underlying websocket connection's
+// close handshake completes (see the ws.SetCloseHandler calls in
+// UpgradeAndListen and Dialer.DialContext). Mux uses this to tear itself
+// down without every caller having to wire that up by hand
+func (conn *Connection) OnClose(fn func()) {
+	conn.closeHooksLock.Lock()
+	conn.closeHooks = append(conn.closeHooks, fn)
+	conn.closeHooksLock.Unlock()
+}
+
+// runCloseHooks invokes every hook registered with OnClose, in order
+func (conn *Connection) runCloseHooks() {
+	conn.closeHooksLock.Lock()
+	hooks := conn.closeHooks
+	conn.closeHooksLock.Unlock()
+	for _, fn := range hooks {
+		fn()
+	}
+}
+
 // Send function for sending packets to the client. Will only send if
 // the connection is open. Acquires write locks before sending packet
 func (conn *Connection) Send(packet Packet) {
 	if conn.Open { // If the connection is open
 		conn.Lock.Lock() // Acquire write lock
-		err := MarshalPacket(conn.WriteBuffer, packet)
+		err := conn.WriteBuffer.WriteVarInt(packet.Id)
 		if err == nil {
-			_ = conn.WriteMessage(websocket.BinaryMessage, conn.WriteBuffer.Bytes())
+			codec := Codec(BinaryCodec{})
+			if conn.System != nil && conn.System.Options.Codec != nil {
+				codec = conn.System.Options.Codec
+			}
+			err = codec.Encode(conn.WriteBuffer, packet.Data)
+		}
+		if err == nil {
+			payload := conn.WriteBuffer.Bytes()
+			if conn.System != nil && conn.System.Options.EnableCompression &&
+				len(payload) >= conn.System.Options.CompressionThreshold {
+				// The transport may already be negotiating permessage-deflate,
+				// but fall back to compressing the frame ourselves in case it
+				// isn't (e.g. the peer didn't advertise support for it)
+				if compressed, ok := compressFallback(payload, conn.System.Options.CompressionLevel); ok {
+					payload = compressed
+				}
+			}
+			_ = conn.WriteMessage(websocket.BinaryMessage, payload)
 		}
 		conn.WriteBuffer.Reset()
 		conn.Lock.Unlock() // Release write lock
@@ -74,11 +144,16 @@ func (conn *Connection) Send(packet Packet) {
 // UpgradeAndListen upgrades the provided http connection to a websocket connection and listens for packet
 // data in a loop. Calls the provided callback function before starting the loop
 func (s *PacketSystem) UpgradeAndListen(w http.ResponseWriter, r *http.Request, callback func(conn *Connection, err error)) {
-	ws, err := upgrader.Upgrade(w, r, nil) // Upgrade the connection
-	if err != nil {                        // If we couldn't upgrade the connection
+	u := upgrader
+	u.EnableCompression = s.Options.EnableCompression
+	ws, err := u.Upgrade(w, r, nil) // Upgrade the connection
+	if err != nil {                 // If we couldn't upgrade the connection
 		callback(nil, err) // Call the callback with the error
 		return
 	}
+	if s.Options.EnableCompression {
+		_ = ws.SetCompressionLevel(s.Options.CompressionLevel)
+	}
 
 	// Create a new connection structure
 	conn := &Connection{
@@ -87,12 +162,14 @@ func (s *PacketSystem) UpgradeAndListen(w http.ResponseWriter, r *http.Request,
 		Conn:        ws,
 		ReadBuffer:  NewPacketBuffer(),
 		WriteBuffer: NewPacketBuffer(),
+		System:      s,
 	}
 
 	// When the websocket connection becomes closed
 	ws.SetCloseHandler(func(code int, text string) error {
 		// Set the connection open to false
 		conn.Open = false
+		conn.runCloseHooks()
 		return nil
 	})
 
@@ -100,6 +177,8 @@ func (s *PacketSystem) UpgradeAndListen(w http.ResponseWriter, r *http.Request,
 	// in ws.Close being called after this function is finished executing
 	defer func(ws *websocket.Conn) { _ = ws.Close() }(ws)
 
+	installCodecNegotiation(s, conn)
+
 	// Call the callback with the newly created connection
 	callback(conn, nil)
 
@@ -115,11 +194,14 @@ func (s *PacketSystem) UpgradeAndListen(w http.ResponseWriter, r *http.Request,
 // packets that have the provided id. The handler function will be called
 // with the packet data whenever one is received
 func AddHandler[T any](s *PacketSystem, id VarInt, handler func(packet *T)) {
-	s.Handlers[id] = func(c *Connection) { // Set the packet decoder for this ID
+	decoder := func(c *Connection) { // Set the packet decoder for this ID
 		out := new(T) // Create a new instance of the output type
-		_ = UnMarshalPacket(c.ReadBuffer, out)
+		_ = s.Options.Codec.Decode(c.ReadBuffer, out)
 		handler(out)
 	}
+	s.handlersLock.Lock()
+	s.Handlers[id] = decoder
+	s.handlersLock.Unlock()
 }
 
 // DecodePacket handles decoding of any packets received by the packet system. Uses the connection
@@ -133,13 +215,40 @@ func (s *PacketSystem) DecodePacket(c *Connection) error {
 	if t != websocket.BinaryMessage {
 		return nil
 	}
-	c.ReadBuffer.Buffer = bytes.NewBuffer(m)
+	return s.decodeFrame(c, m)
+}
+
+// decodeFrame decodes one frame of packet data, transparently inflating and
+// recursing once if the frame is a CompressedPacketId envelope (the
+// application level compression fallback used when the transport didn't
+// negotiate permessage-deflate itself). The CompressedPacketId check only
+// applies when EnableCompression is on: that ID is otherwise just another
+// application packet id, and treating it as a reserved envelope
+// unconditionally would break handlers registered on it with compression
+// off (the default)
+func (s *PacketSystem) decodeFrame(c *Connection, data []byte) error {
+	c.ReadBuffer.Buffer = bytes.NewBuffer(data)
 	id, err := binary.ReadUvarint(c.ReadBuffer)
 	if err != nil {
 		return err
 	}
+
+	if s.Options.EnableCompression && VarInt(id) == CompressedPacketId {
+		env := new(compressedEnvelope)
+		if err = UnMarshalPacket(c.ReadBuffer, env); err != nil {
+			return err
+		}
+		raw, err := inflateFallback(env.Data)
+		if err != nil {
+			return err
+		}
+		return s.decodeFrame(c, raw)
+	}
+
+	s.handlersLock.RLock()
 	handler, exists := s.Handlers[VarInt(id)] // Retrieve a handler for the packet
-	if !exists {                              // We don't have a packet handler for this packet
+	s.handlersLock.RUnlock()
+	if !exists { // We don't have a packet handler for this packet
 		return errors.New(fmt.Sprintf("No packet handler for packet %d", id))
 	} else {
 		handler(c) // Call the handler function
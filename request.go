@@ -0,0 +1,203 @@
+package gowsps
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// RequestPacketId is the packet ID reserved on the PacketSystem for the
+// request/response envelope. Applications should not register their own
+// handler for this ID; use AddRequestHandler instead.
+const RequestPacketId VarInt = 0x01
+
+// DefaultRequestTimeout is the timeout applied to Connection.Request calls
+// made with a context that has no deadline of its own
+const DefaultRequestTimeout = 10 * time.Second
+
+// DefaultMaxInFlightRequests bounds how many outgoing requests a
+// Connection will have pending replies for at once. Request returns
+// ErrTooManyInFlight once this limit is reached
+const DefaultMaxInFlightRequests = 256
+
+// ErrTooManyInFlight is returned by Connection.Request when
+// DefaultMaxInFlightRequests pending requests are already awaiting a reply
+var ErrTooManyInFlight = errors.New("too many in-flight requests")
+
+// requestEnvelope is the wire structure for both requests and replies,
+// carried as the Data of every packet sent with RequestPacketId
+type requestEnvelope struct {
+	CorrelationId VarInt
+	IsReply       bool
+	PacketId      VarInt
+	HasError      bool
+	Error         string
+	Data          []byte
+}
+
+// requestState tracks the reply channel per pending correlation ID and
+// the next ID to hand out
+type requestState struct {
+	lock        sync.Mutex
+	nextId      VarInt
+	pending     map[VarInt]chan requestEnvelope
+	maxInFlight int
+}
+
+// installRequestState lazily wires RequestPacketId into s the first time
+// it is needed
+func installRequestState(s *PacketSystem) *requestState {
+	s.handlersLock.Lock()
+	defer s.handlersLock.Unlock()
+	if s.requests != nil {
+		return s.requests
+	}
+	rs := &requestState{
+		pending:     map[VarInt]chan requestEnvelope{},
+		maxInFlight: DefaultMaxInFlightRequests,
+	}
+	s.requests = rs
+	s.Handlers[RequestPacketId] = func(c *Connection) {
+		env := new(requestEnvelope)
+		_ = s.Options.Codec.Decode(c.ReadBuffer, env)
+		rs.onEnvelope(s, c, env)
+	}
+	return rs
+}
+
+// onEnvelope routes an incoming requestEnvelope to the waiting Request
+// call if it's a reply, or to its registered handler otherwise
+func (rs *requestState) onEnvelope(s *PacketSystem, c *Connection, env *requestEnvelope) {
+	if env.IsReply {
+		rs.lock.Lock()
+		ch, ok := rs.pending[env.CorrelationId]
+		if ok {
+			delete(rs.pending, env.CorrelationId)
+		}
+		rs.lock.Unlock()
+		if ok {
+			ch <- *env
+		}
+		return
+	}
+
+	s.handlersLock.RLock()
+	handler, ok := s.requestHandlers[env.PacketId]
+	s.handlersLock.RUnlock()
+	if !ok {
+		return
+	}
+	handler(c, env)
+}
+
+// Request sends packet and blocks until a matching reply arrives, ctx is
+// done, or the per-call timeout elapses (DefaultRequestTimeout when ctx
+// has no deadline)
+func (conn *Connection) Request(ctx context.Context, packet Packet) (*Packet, error) {
+	rs := installRequestState(conn.System)
+
+	dataBuf := NewPacketBuffer()
+	if err := marshalValue(dataBuf, reflect.ValueOf(packet.Data), fieldTag{}); err != nil {
+		return nil, err
+	}
+
+	rs.lock.Lock()
+	if len(rs.pending) >= rs.maxInFlight {
+		rs.lock.Unlock()
+		return nil, ErrTooManyInFlight
+	}
+	id := rs.nextId
+	rs.nextId++
+	replyCh := make(chan requestEnvelope, 1)
+	rs.pending[id] = replyCh
+	rs.lock.Unlock()
+
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, DefaultRequestTimeout)
+		defer cancel()
+	}
+
+	conn.Send(Packet{Id: RequestPacketId, Data: requestEnvelope{
+		CorrelationId: id,
+		IsReply:       false,
+		PacketId:      packet.Id,
+		Data:          dataBuf.Bytes(),
+	}})
+
+	select {
+	case env := <-replyCh:
+		if env.HasError {
+			return nil, errors.New(env.Error)
+		}
+		return &Packet{Id: env.PacketId, Data: env.Data}, nil
+	case <-ctx.Done():
+		rs.lock.Lock()
+		delete(rs.pending, id)
+		rs.lock.Unlock()
+		return nil, ctx.Err()
+	}
+}
+
+// Request sends packet on conn and decodes the reply into a *Resp, the
+// generic counterpart to Connection.Request for callers who know the
+// response type
+func Request[Resp any](ctx context.Context, conn *Connection, packet Packet) (*Resp, error) {
+	reply, err := conn.Request(ctx, packet)
+	if err != nil {
+		return nil, err
+	}
+	raw, ok := reply.Data.([]byte)
+	if !ok {
+		return nil, fmt.Errorf("wsps: Request: unexpected reply data type %T", reply.Data)
+	}
+	respBuf := NewPacketBuffer()
+	respBuf.Buffer.Write(raw)
+	out := new(Resp)
+	if err := UnMarshalPacket(respBuf, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// AddRequestHandler registers a request/response handler for requests
+// sent with the given packet id: the envelope's payload is decoded into
+// Req, handler is invoked, and its Resp (or error) is sent back as the
+// reply
+func AddRequestHandler[Req any, Resp any](s *PacketSystem, id VarInt, handler func(*Req) (*Resp, error)) {
+	installRequestState(s)
+	decoder := func(c *Connection, env *requestEnvelope) {
+		req := new(Req)
+		reqBuf := NewPacketBuffer()
+		reqBuf.Buffer.Write(env.Data)
+		_ = UnMarshalPacket(reqBuf, req)
+
+		reply := requestEnvelope{CorrelationId: env.CorrelationId, IsReply: true, PacketId: id}
+
+		resp, err := handler(req)
+		if err != nil {
+			reply.HasError = true
+			reply.Error = err.Error()
+		} else {
+			respBuf := NewPacketBuffer()
+			if err := marshalValue(respBuf, reflect.ValueOf(*resp), fieldTag{}); err != nil {
+				reply.HasError = true
+				reply.Error = fmt.Sprintf("failed to encode response: %v", err)
+			} else {
+				reply.Data = respBuf.Bytes()
+			}
+		}
+
+		c.Send(Packet{Id: RequestPacketId, Data: reply})
+	}
+
+	s.handlersLock.Lock()
+	if s.requestHandlers == nil {
+		s.requestHandlers = map[VarInt]func(*Connection, *requestEnvelope){}
+	}
+	s.requestHandlers[id] = decoder
+	s.handlersLock.Unlock()
+}
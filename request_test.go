@@ -0,0 +1,79 @@
+package gowsps
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type greetRequest struct {
+	Name string
+}
+
+type greetResponse struct {
+	Greeting string
+}
+
+const greetRequestPacketId VarInt = 0x10
+
+// newRequestPair starts an UpgradeAndListen server with a greetRequestPacketId
+// handler registered via AddRequestHandler and dials it, returning the
+// client Connection once the connection is up
+func newRequestPair(t *testing.T) *Connection {
+	t.Helper()
+	serverSys := NewPacketSystem()
+	AddRequestHandler(serverSys, greetRequestPacketId, func(req *greetRequest) (*greetResponse, error) {
+		return &greetResponse{Greeting: "hello, " + req.Name}, nil
+	})
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		serverSys.UpgradeAndListen(w, r, func(conn *Connection, err error) {
+			if err != nil {
+				t.Error(err)
+			}
+		})
+	}))
+	t.Cleanup(ts.Close)
+
+	clientSys := NewPacketSystem()
+	wsURL := "ws" + ts.URL[len("http"):]
+	conn, _, err := NewDialer(clientSys).Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	return conn
+}
+
+// TestRequestGenericRoundTrip confirms the generic Request function decodes
+// the reply into a concrete *greetResponse, instead of the caller having to
+// decode Connection.Request's raw []byte payload itself
+func TestRequestGenericRoundTrip(t *testing.T) {
+	conn := newRequestPair(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	resp, err := Request[greetResponse](ctx, conn, Packet{Id: greetRequestPacketId, Data: greetRequest{Name: "World"}})
+	if err != nil {
+		t.Fatalf("Request: %v", err)
+	}
+	if resp.Greeting != "hello, World" {
+		t.Fatalf("got %+v", resp)
+	}
+}
+
+// TestRequestTimeout confirms a Request against a packet id with no
+// registered handler times out via ctx rather than hanging forever
+func TestRequestTimeout(t *testing.T) {
+	conn := newRequestPair(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	_, err := Request[greetResponse](ctx, conn, Packet{Id: greetRequestPacketId + 1, Data: greetRequest{Name: "World"}})
+	if err != context.DeadlineExceeded {
+		t.Fatalf("got err %v want context.DeadlineExceeded", err)
+	}
+}
@@ -0,0 +1,204 @@
+package gowsps
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// ErrStreamClosed is returned from Read/Write once a Stream has been
+// closed locally or by the peer
+var ErrStreamClosed = errors.New("stream closed")
+
+// Stream is one logical, bidirectional connection multiplexed over a Mux.
+// It satisfies net.Conn
+type Stream struct {
+	id  VarInt
+	mux *Mux
+
+	lock        sync.Mutex
+	readBuf     bytes.Buffer
+	readCond    *sync.Cond
+	closed      bool
+	closeErr    error
+	peerClosed  bool
+	writeClosed bool
+
+	credit     VarInt
+	creditCond *sync.Cond
+}
+
+// newStream builds a Stream bound to id on the given Mux with a full
+// initial write window
+func newStream(m *Mux, id VarInt) *Stream {
+	s := &Stream{
+		id:     id,
+		mux:    m,
+		credit: initialStreamWindow,
+	}
+	s.readCond = sync.NewCond(&s.lock)
+	s.creditCond = sync.NewCond(&s.lock)
+	return s
+}
+
+// Read blocks until data is available, the peer half-closes the stream, or
+// the stream is closed
+func (s *Stream) Read(b []byte) (int, error) {
+	s.lock.Lock()
+	for s.readBuf.Len() == 0 && !s.peerClosed && !s.closed {
+		s.readCond.Wait()
+	}
+	if s.readBuf.Len() == 0 {
+		if s.closed {
+			err := s.closeErrLocked()
+			s.lock.Unlock()
+			return 0, err
+		}
+		s.lock.Unlock()
+		return 0, io.EOF
+	}
+	n, err := s.readBuf.Read(b)
+	s.lock.Unlock()
+
+	if n > 0 {
+		// Grant credit back as data is consumed, not as it arrives (see
+		// pushData), so back-pressure actually applies
+		var creditBuf [binary.MaxVarintLen64]byte
+		credited := binary.PutUvarint(creditBuf[:], uint64(n))
+		s.mux.send(s.id, muxOpWindowUpdate, creditBuf[:credited])
+	}
+	return n, err
+}
+
+// Write sends b to the peer as one or more muxOpData frames, blocking
+// while waiting for write credit from a WINDOW_UPDATE
+func (s *Stream) Write(b []byte) (int, error) {
+	written := 0
+	for len(b) > 0 {
+		s.lock.Lock()
+		for s.credit == 0 && !s.closed && !s.writeClosed {
+			s.creditCond.Wait()
+		}
+		if s.closed || s.writeClosed {
+			err := s.closeErrLocked()
+			s.lock.Unlock()
+			return written, err
+		}
+		n := len(b)
+		if VarInt(n) > s.credit {
+			n = int(s.credit)
+		}
+		s.credit -= VarInt(n)
+		s.lock.Unlock()
+
+		s.mux.send(s.id, muxOpData, b[:n])
+		written += n
+		b = b[n:]
+	}
+	return written, nil
+}
+
+// pushData appends data received from the peer to the read buffer
+func (s *Stream) pushData(data []byte) {
+	s.lock.Lock()
+	s.readBuf.Write(data)
+	s.readCond.Broadcast()
+	s.lock.Unlock()
+}
+
+// addCredit grants n additional bytes of write credit, unblocking any
+// Write waiting on it
+func (s *Stream) addCredit(n VarInt) {
+	s.lock.Lock()
+	s.credit += n
+	s.creditCond.Broadcast()
+	s.lock.Unlock()
+}
+
+// closeRemote marks the stream half-closed for reading because the peer
+// sent a muxOpClose frame
+func (s *Stream) closeRemote() {
+	s.lock.Lock()
+	s.peerClosed = true
+	s.readCond.Broadcast()
+	s.lock.Unlock()
+}
+
+// closeLocal closes the stream locally, unblocking any pending Read or
+// Write with err (or ErrStreamClosed if err is nil)
+func (s *Stream) closeLocal(err error) {
+	s.lock.Lock()
+	if s.closed {
+		s.lock.Unlock()
+		return
+	}
+	s.closed = true
+	s.closeErr = err
+	s.readCond.Broadcast()
+	s.creditCond.Broadcast()
+	s.lock.Unlock()
+
+	s.mux.lock.Lock()
+	delete(s.mux.streams, s.id)
+	s.mux.lock.Unlock()
+}
+
+// Close fully closes the stream: it sends a muxOpClose frame to the peer
+// (unless CloseWrite already did) and releases it locally. Call CloseWrite
+// instead if the stream is still expected to receive data
+func (s *Stream) Close() error {
+	s.lock.Lock()
+	alreadyClosed := s.closed
+	writeClosed := s.writeClosed
+	s.lock.Unlock()
+	if alreadyClosed {
+		return nil
+	}
+	if !writeClosed {
+		s.mux.send(s.id, muxOpClose, nil)
+	}
+	s.closeLocal(nil)
+	return nil
+}
+
+// CloseWrite half-closes the stream for writing, leaving the read side
+// (and the Mux's bookkeeping for this stream) intact
+func (s *Stream) CloseWrite() error {
+	s.lock.Lock()
+	if s.closed || s.writeClosed {
+		s.lock.Unlock()
+		return nil
+	}
+	s.writeClosed = true
+	s.creditCond.Broadcast()
+	s.lock.Unlock()
+	s.mux.send(s.id, muxOpClose, nil)
+	return nil
+}
+
+func (s *Stream) closeErrLocked() error {
+	if s.closeErr != nil {
+		return s.closeErr
+	}
+	return ErrStreamClosed
+}
+
+// LocalAddr, RemoteAddr, and the deadline setters exist only so Stream
+// satisfies net.Conn
+func (s *Stream) LocalAddr() net.Addr                { return streamAddr(s.id) }
+func (s *Stream) RemoteAddr() net.Addr               { return streamAddr(s.id) }
+func (s *Stream) SetDeadline(t time.Time) error      { return nil }
+func (s *Stream) SetReadDeadline(t time.Time) error  { return nil }
+func (s *Stream) SetWriteDeadline(t time.Time) error { return nil }
+
+// streamAddr is a minimal net.Addr identifying a Stream by its StreamId
+type streamAddr VarInt
+
+func (a streamAddr) Network() string { return "mux" }
+func (a streamAddr) String() string  { return "stream" }
+
+var _ net.Conn = (*Stream)(nil)
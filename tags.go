@@ -0,0 +1,149 @@
+package gowsps
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// lenWidth selects how a slice/string length prefix is encoded on the wire
+type lenWidth int
+
+const (
+	lenWidthVarint lenWidth = iota // default: binary.PutUvarint, same as an untagged field
+	lenWidthUint16                 // fixed 2 byte big-endian length prefix
+	lenWidthUint32                 // fixed 4 byte big-endian length prefix
+)
+
+// fieldTag is the parsed form of a `wsps:"..."` struct tag
+type fieldTag struct {
+	Varint   bool     // force varint encoding for an otherwise fixed-width integer
+	Fixed    bool     // force fixed-width encoding for a VarInt
+	Optional bool     // prefix with a presence flag, skipping zero/nil values
+	Skip     bool     // never read or write this field
+	LenWidth lenWidth // width of the length prefix for a string or slice field
+	Enum     string   // name of a RegisterEnum set this field's value must belong to
+}
+
+// parseFieldTag parses the comma separated options of a `wsps:"..."` tag
+func parseFieldTag(raw string) fieldTag {
+	var tag fieldTag
+	if raw == "" {
+		return tag
+	}
+	for _, part := range strings.Split(raw, ",") {
+		switch {
+		case part == "varint":
+			tag.Varint = true
+		case part == "fixed":
+			tag.Fixed = true
+		case part == "optional":
+			tag.Optional = true
+		case part == "skip":
+			tag.Skip = true
+		case strings.HasPrefix(part, "len="):
+			switch strings.TrimPrefix(part, "len=") {
+			case "uint16":
+				tag.LenWidth = lenWidthUint16
+			case "uint32":
+				tag.LenWidth = lenWidthUint32
+			case "varint":
+				tag.LenWidth = lenWidthVarint
+			}
+		case strings.HasPrefix(part, "enum="):
+			tag.Enum = strings.TrimPrefix(part, "enum=")
+		}
+	}
+	return tag
+}
+
+// fieldInfo pairs a struct field's index (as passed to reflect.Value.Field)
+// with its parsed tag
+type fieldInfo struct {
+	Index int
+	Tag   fieldTag
+}
+
+// typeInfo is the cached, per-struct-type result of reading every field's
+// `wsps` tag once. Fields with a `skip` tag are omitted entirely
+type typeInfo struct {
+	Fields []fieldInfo
+}
+
+// typeInfoCache memoises typeInfo per reflect.Type so repeated
+// marshal/unmarshal calls for the same struct don't re-parse its tags
+var typeInfoCache sync.Map // map[reflect.Type]*typeInfo
+
+// getTypeInfo returns (building and caching if necessary) the typeInfo for
+// struct type t
+func getTypeInfo(t reflect.Type) *typeInfo {
+	if cached, ok := typeInfoCache.Load(t); ok {
+		return cached.(*typeInfo)
+	}
+	info := &typeInfo{}
+	for i := 0; i < t.NumField(); i++ {
+		tag := parseFieldTag(t.Field(i).Tag.Get("wsps"))
+		if tag.Skip {
+			continue
+		}
+		info.Fields = append(info.Fields, fieldInfo{Index: i, Tag: tag})
+	}
+	actual, _ := typeInfoCache.LoadOrStore(t, info)
+	return actual.(*typeInfo)
+}
+
+// enumRegistry holds the allowed value sets registered with RegisterEnum,
+// keyed by the name used in an `enum=name` field tag
+var (
+	enumRegistryLock sync.RWMutex
+	enumRegistry     = map[string]map[uint64]bool{}
+)
+
+// RegisterEnum registers name as a valid `enum=name` target accepting only
+// values. A field tagged `wsps:"enum=name"` fails to marshal or unmarshal
+// if its value is not one of values
+func RegisterEnum(name string, values ...uint64) {
+	set := make(map[uint64]bool, len(values))
+	for _, v := range values {
+		set[v] = true
+	}
+	enumRegistryLock.Lock()
+	enumRegistry[name] = set
+	enumRegistryLock.Unlock()
+}
+
+// validateEnum checks value against the set registered under name,
+// returning an error if the set is unknown or does not contain value
+func validateEnum(name string, value uint64) error {
+	enumRegistryLock.RLock()
+	set, ok := enumRegistry[name]
+	enumRegistryLock.RUnlock()
+	if !ok {
+		return fmt.Errorf("wsps: enum %q is not registered", name)
+	}
+	if !set[value] {
+		return fmt.Errorf("wsps: value %d is not a member of enum %q", value, name)
+	}
+	return nil
+}
+
+// toUint64 widens the integer kinds marshalPrimitive/unmarshalPrimitive
+// support (besides VarInt) to uint64 for varint/enum handling
+func toUint64(v any) (u uint64, ok bool) {
+	switch x := v.(type) {
+	case uint8:
+		return uint64(x), true
+	case uint16:
+		return uint64(x), true
+	case uint32:
+		return uint64(x), true
+	case int8:
+		return uint64(x), true
+	case int16:
+		return uint64(x), true
+	case int32:
+		return uint64(x), true
+	}
+	return 0, false
+}
@@ -0,0 +1,112 @@
+package gowsps
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+type tagsTestStruct struct {
+	Fixed    uint32 `wsps:"fixed"`
+	Varint   uint32 `wsps:"varint"`
+	Optional string `wsps:"optional"`
+	Skipped  string `wsps:"skip"`
+	Len16    []byte `wsps:"len=uint16"`
+	Len32    []byte `wsps:"len=uint32"`
+	LenVar   []byte `wsps:"len=varint"`
+}
+
+func roundTripTagsStruct(t *testing.T, in tagsTestStruct) tagsTestStruct {
+	t.Helper()
+	buf := NewPacketBuffer()
+	if err := MarshalPacket(buf, Packet{Id: 0x01, Data: in}); err != nil {
+		t.Fatalf("MarshalPacket: %v", err)
+	}
+
+	if _, err := buf.ReadByte(); err != nil { // consume the leading packet id varint byte
+		t.Fatalf("ReadByte: %v", err)
+	}
+
+	out := new(tagsTestStruct)
+	if err := UnMarshalPacket(buf, out); err != nil {
+		t.Fatalf("UnMarshalPacket: %v", err)
+	}
+	return *out
+}
+
+// TestTagsRoundTrip exercises every wsps struct tag option together,
+// checking that fixed, varint, optional, len-width and skip fields all
+// survive a marshal/unmarshal round trip
+func TestTagsRoundTrip(t *testing.T) {
+	in := tagsTestStruct{
+		Fixed:    123456,
+		Varint:   42,
+		Optional: "present",
+		Skipped:  "never written",
+		Len16:    bytes.Repeat([]byte("a"), 10),
+		Len32:    bytes.Repeat([]byte("b"), 10),
+		LenVar:   bytes.Repeat([]byte("c"), 10),
+	}
+
+	got := roundTripTagsStruct(t, in)
+
+	want := in
+	want.Skipped = "" // skip fields are never written, so they decode as the zero value
+
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %+v want %+v", got, want)
+	}
+}
+
+// TestTagsOptionalZeroValueOmitted confirms an `optional` field holding its
+// zero value, written as a cleared presence flag with no value bytes, decodes
+// back as the zero value
+func TestTagsOptionalZeroValueOmitted(t *testing.T) {
+	in := tagsTestStruct{Len16: []byte{}, Len32: []byte{}, LenVar: []byte{}}
+	got := roundTripTagsStruct(t, in)
+	if got.Optional != "" {
+		t.Fatalf("got Optional %q want empty", got.Optional)
+	}
+}
+
+// TestTagsEnumValidation confirms a field tagged `enum=name` marshals a
+// registered value and rejects one that isn't a member of the set
+func TestTagsEnumValidation(t *testing.T) {
+	type enumTestStruct struct {
+		Status VarInt `wsps:"enum=tagsTestStatus"`
+	}
+	RegisterEnum("tagsTestStatus", 1, 2, 3)
+
+	buf := NewPacketBuffer()
+	if err := MarshalPacket(buf, Packet{Id: 0x01, Data: enumTestStruct{Status: 2}}); err != nil {
+		t.Fatalf("MarshalPacket: %v", err)
+	}
+
+	buf2 := NewPacketBuffer()
+	err := MarshalPacket(buf2, Packet{Id: 0x01, Data: enumTestStruct{Status: 99}})
+	if err == nil {
+		t.Fatal("expected an error marshaling a value outside the registered enum")
+	}
+}
+
+// TestTagsEnumValidationAppliesToSliceElements confirms `enum=name` on a
+// slice-typed field is checked against every element, not silently
+// dropped the way it was before elementTag propagated the field's tag
+// down into marshalSlice/unmarshalSlice
+func TestTagsEnumValidationAppliesToSliceElements(t *testing.T) {
+	type enumSliceStruct struct {
+		Status []VarInt `wsps:"enum=tagsTestSliceStatus"`
+	}
+	RegisterEnum("tagsTestSliceStatus", 1, 2, 3)
+
+	buf := NewPacketBuffer()
+	if err := MarshalPacket(buf, Packet{Id: 0x01, Data: enumSliceStruct{Status: []VarInt{1, 2, 3}}}); err != nil {
+		t.Fatalf("MarshalPacket: %v", err)
+	}
+
+	buf2 := NewPacketBuffer()
+	err := MarshalPacket(buf2, Packet{Id: 0x01, Data: enumSliceStruct{Status: []VarInt{1, 99}}})
+	if err == nil {
+		t.Fatal("expected an error marshaling a slice element outside the registered enum")
+	}
+}